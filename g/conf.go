@@ -0,0 +1,121 @@
+// Copyright 2017 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package g holds process-global state: the parsed server config and the
+// defaults it falls back to when a key is absent from frps.ini.
+package g
+
+// GlbServerCfg is the process-wide server config, populated at start up
+// from LoadServerCommonConf's result (and updated in place by the
+// management API's reload handler for the fields it allows changing at
+// runtime).
+var GlbServerCfg *ServerCfg = &ServerCfg{ServerCommonConf: GetDefaultServerCommonConf()}
+
+type ServerCfg struct {
+	ServerCommonConf
+}
+
+// ServerCommonConf is the [common] section of frps.ini, plus the
+// feature-specific sections ([auth], [metrics], [events], [manage],
+// [mtls]) that extend it.
+type ServerCommonConf struct {
+	ConfigFile string
+
+	BindAddr    string
+	BindPort    int
+	BindUdpPort int
+	KcpBindPort int
+
+	ProxyBindAddr  string
+	VhostHttpPort  int
+	VhostHttpsPort int
+
+	DashboardAddr string
+	DashboardPort int
+
+	AssetsDir string
+
+	LogFile    string
+	LogWay     string
+	LogLevel   string
+	LogMaxDays int64
+
+	// Token is the shared secret behind the legacy token auth method; see
+	// AuthMethod below.
+	Token       string
+	AuthTimeout int64
+
+	SubDomainHost string
+	TcpMux        bool
+	AllowPorts    map[int]struct{}
+
+	// [auth]: see server.NewAuthenticator.
+	//   method = token | jwt | oidc
+	AuthMethod       string
+	AuthJWTSecret    string
+	AuthJWTIssuer    string
+	AuthOIDCIssuer   string
+	AuthOIDCJWKSUrl  string
+	AuthOIDCAudience string
+
+	// [metrics]: see server.metrics.ServeMetrics.
+	MetricsEnable bool
+	MetricsPath   string
+
+	// [events]: see server.NewEventSink.
+	EventsFile       string
+	EventsWebhookUrl string
+
+	// QuicBindPort is the UDP port the QUIC control-channel transport
+	// listens on; 0 disables it. See server.NewQuicListener.
+	QuicBindPort int
+
+	// [manage]: see server.ManageApi.
+	ManageApiEnable bool
+	// ManageApiToken, if set, is required as a bearer token on every
+	// management api route. Leave empty only if the dashboard sits behind
+	// its own auth or a reverse proxy that already restricts access.
+	ManageApiToken string
+	// ProvisionStorePath is where pre-provisioned proxies (registered
+	// through /api/proxies/provision) are persisted across restarts.
+	ProvisionStorePath string
+
+	// [mtls]: see server.tlsServerConfig and server.NewTlsListener. A
+	// client presenting a certificate trusted by MtlsCaFile is
+	// authenticated by the handshake itself. An MtlsBindPort equal to
+	// BindPort multiplexes mTLS onto the control port (see mtlsMuxOn in
+	// server.NewService); any other value opens a separate listener on it.
+	MtlsEnable   bool
+	MtlsCertFile string
+	MtlsKeyFile  string
+	MtlsCaFile   string
+	MtlsBindPort int
+}
+
+// GetDefaultServerCommonConf returns the conf LoadServerCommonConf starts
+// from before overlaying whatever the ini file sets.
+func GetDefaultServerCommonConf() ServerCommonConf {
+	return ServerCommonConf{
+		BindAddr:      "0.0.0.0",
+		BindPort:      7000,
+		ProxyBindAddr: "0.0.0.0",
+		LogWay:        "console",
+		LogLevel:      "info",
+		LogMaxDays:    3,
+		AuthTimeout:   900,
+		AuthMethod:    "token",
+		AllowPorts:    make(map[int]struct{}),
+		MetricsPath:   "/metrics",
+	}
+}
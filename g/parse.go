@@ -0,0 +1,236 @@
+// Copyright 2017 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package g
+
+import (
+	"strconv"
+	"strings"
+
+	ini "github.com/vaughan0/go-ini"
+)
+
+// LoadServerCommonConf parses the [common] section (plus the
+// feature-specific sections it delegates to) of an frps.ini file.
+func LoadServerCommonConf(content string) (cfg ServerCommonConf, err error) {
+	cfg = GetDefaultServerCommonConf()
+
+	conf, err := ini.Load(strings.NewReader(content))
+	if err != nil {
+		return cfg, err
+	}
+	common := conf.Section("common")
+
+	if v, ok := common["bind_addr"]; ok {
+		cfg.BindAddr = v
+	}
+	if v, ok := common["bind_port"]; ok {
+		cfg.BindPort, _ = strconv.Atoi(v)
+	}
+	if v, ok := common["bind_udp_port"]; ok {
+		cfg.BindUdpPort, _ = strconv.Atoi(v)
+	}
+	if v, ok := common["kcp_bind_port"]; ok {
+		cfg.KcpBindPort, _ = strconv.Atoi(v)
+	}
+	if v, ok := common["proxy_bind_addr"]; ok {
+		cfg.ProxyBindAddr = v
+	}
+	if v, ok := common["vhost_http_port"]; ok {
+		cfg.VhostHttpPort, _ = strconv.Atoi(v)
+	}
+	if v, ok := common["vhost_https_port"]; ok {
+		cfg.VhostHttpsPort, _ = strconv.Atoi(v)
+	}
+	if v, ok := common["dashboard_addr"]; ok {
+		cfg.DashboardAddr = v
+	}
+	if v, ok := common["dashboard_port"]; ok {
+		cfg.DashboardPort, _ = strconv.Atoi(v)
+	}
+	if v, ok := common["assets_dir"]; ok {
+		cfg.AssetsDir = v
+	}
+	if v, ok := common["log_file"]; ok {
+		cfg.LogFile = v
+	}
+	if v, ok := common["log_way"]; ok {
+		cfg.LogWay = v
+	}
+	if v, ok := common["log_level"]; ok {
+		cfg.LogLevel = v
+	}
+	if v, ok := common["log_max_days"]; ok {
+		cfg.LogMaxDays, _ = strconv.ParseInt(v, 10, 64)
+	}
+	if v, ok := common["token"]; ok {
+		cfg.Token = v
+	}
+	if v, ok := common["authentication_timeout"]; ok {
+		cfg.AuthTimeout, _ = strconv.ParseInt(v, 10, 64)
+	}
+	if v, ok := common["subdomain_host"]; ok {
+		cfg.SubDomainHost = v
+	}
+	if v, ok := common["tcp_mux"]; ok {
+		cfg.TcpMux, _ = strconv.ParseBool(v)
+	}
+	if v, ok := common["allow_ports"]; ok {
+		cfg.AllowPorts = parsePortRanges(v)
+	}
+	if v, ok := common["quic_bind_port"]; ok {
+		cfg.QuicBindPort, _ = strconv.Atoi(v)
+	}
+
+	loadAuthConf(conf, &cfg)
+	loadMetricsConf(conf, &cfg)
+	loadEventsConf(conf, &cfg)
+	loadManageConf(conf, &cfg)
+	loadMtlsConf(conf, &cfg)
+	return cfg, nil
+}
+
+// loadAuthConf parses the [auth] section:
+//
+//	[auth]
+//	method = token | jwt | oidc
+//	jwt_secret = ...
+//	jwt_issuer = ...
+//	oidc_issuer = ...
+//	oidc_jwks_url = ...
+//	oidc_audience = ...
+func loadAuthConf(conf ini.File, cfg *ServerCommonConf) {
+	auth := conf.Section("auth")
+	if v, ok := auth["method"]; ok {
+		cfg.AuthMethod = v
+	}
+	if v, ok := auth["jwt_secret"]; ok {
+		cfg.AuthJWTSecret = v
+	}
+	if v, ok := auth["jwt_issuer"]; ok {
+		cfg.AuthJWTIssuer = v
+	}
+	if v, ok := auth["oidc_issuer"]; ok {
+		cfg.AuthOIDCIssuer = v
+	}
+	if v, ok := auth["oidc_jwks_url"]; ok {
+		cfg.AuthOIDCJWKSUrl = v
+	}
+	if v, ok := auth["oidc_audience"]; ok {
+		cfg.AuthOIDCAudience = v
+	}
+}
+
+// loadMetricsConf parses the [metrics] section:
+//
+//	[metrics]
+//	enable = true
+//	path = /metrics
+func loadMetricsConf(conf ini.File, cfg *ServerCommonConf) {
+	metrics := conf.Section("metrics")
+	if v, ok := metrics["enable"]; ok {
+		cfg.MetricsEnable, _ = strconv.ParseBool(v)
+	}
+	if v, ok := metrics["path"]; ok {
+		cfg.MetricsPath = v
+	}
+}
+
+// loadEventsConf parses the [events] section:
+//
+//	[events]
+//	file = /var/log/frps-events.ndjson
+//	webhook_url = https://example.com/hook
+func loadEventsConf(conf ini.File, cfg *ServerCommonConf) {
+	events := conf.Section("events")
+	if v, ok := events["file"]; ok {
+		cfg.EventsFile = v
+	}
+	if v, ok := events["webhook_url"]; ok {
+		cfg.EventsWebhookUrl = v
+	}
+}
+
+// loadManageConf parses the [manage] section:
+//
+//	[manage]
+//	enable = true
+//	token = s3cr3t
+//	provision_store_path = /var/lib/frps/proxies.json
+func loadManageConf(conf ini.File, cfg *ServerCommonConf) {
+	manage := conf.Section("manage")
+	if v, ok := manage["enable"]; ok {
+		cfg.ManageApiEnable, _ = strconv.ParseBool(v)
+	}
+	if v, ok := manage["token"]; ok {
+		cfg.ManageApiToken = v
+	}
+	if v, ok := manage["provision_store_path"]; ok {
+		cfg.ProvisionStorePath = v
+	}
+}
+
+// loadMtlsConf parses the [mtls] section:
+//
+//	[mtls]
+//	enable = true
+//	cert_file = server.crt
+//	key_file = server.key
+//	ca_file = client-ca.crt
+//	bind_port = 7001
+func loadMtlsConf(conf ini.File, cfg *ServerCommonConf) {
+	mtls := conf.Section("mtls")
+	if v, ok := mtls["enable"]; ok {
+		cfg.MtlsEnable, _ = strconv.ParseBool(v)
+	}
+	if v, ok := mtls["cert_file"]; ok {
+		cfg.MtlsCertFile = v
+	}
+	if v, ok := mtls["key_file"]; ok {
+		cfg.MtlsKeyFile = v
+	}
+	if v, ok := mtls["ca_file"]; ok {
+		cfg.MtlsCaFile = v
+	}
+	if v, ok := mtls["bind_port"]; ok {
+		cfg.MtlsBindPort, _ = strconv.Atoi(v)
+	}
+}
+
+// parsePortRanges turns a comma-separated "80,443,6000-6100" list into the
+// set AllowPorts expects.
+func parsePortRanges(v string) map[int]struct{} {
+	ports := make(map[int]struct{})
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if i := strings.Index(part, "-"); i >= 0 {
+			start, errStart := strconv.Atoi(part[:i])
+			end, errEnd := strconv.Atoi(part[i+1:])
+			if errStart != nil || errEnd != nil {
+				continue
+			}
+			for p := start; p <= end; p++ {
+				ports[p] = struct{}{}
+			}
+			continue
+		}
+		if p, err := strconv.Atoi(part); err == nil {
+			ports[p] = struct{}{}
+		}
+	}
+	return ports
+}
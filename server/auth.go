@@ -0,0 +1,389 @@
+// Copyright 2017 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatedier/frp/g"
+	"github.com/fatedier/frp/models/msg"
+	"github.com/fatedier/frp/utils/util"
+)
+
+// Principal describes the identity a client authenticated as, along with
+// the ACL the server should enforce for everything that client does for
+// the lifetime of its control connection.
+type Principal struct {
+	// Identity is an opaque, human readable name for the authenticated
+	// client (token name, JWT subject, OIDC subject, etc).
+	Identity string
+
+	// AllowedProxyNamePrefixes restricts which proxy names this client may
+	// register. An empty slice means no restriction.
+	AllowedProxyNamePrefixes []string
+
+	// AllowedRemotePorts restricts which remote ports this client may bind.
+	// An empty slice means no restriction.
+	AllowedRemotePorts []int
+
+	// Quota is the maximum number of proxies this client may register at
+	// once. Zero means unlimited.
+	Quota int
+}
+
+// AllowProxyName reports whether name is permitted by the principal's ACL.
+func (p *Principal) AllowProxyName(name string) bool {
+	if p == nil || len(p.AllowedProxyNamePrefixes) == 0 {
+		return true
+	}
+	for _, prefix := range p.AllowedProxyNamePrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowRemotePort reports whether port is permitted by the principal's ACL.
+func (p *Principal) AllowRemotePort(port int) bool {
+	if p == nil || len(p.AllowedRemotePorts) == 0 {
+		return true
+	}
+	for _, allowed := range p.AllowedRemotePorts {
+		if allowed == port {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator verifies a client's Login message and, on success, returns
+// the Principal the server should associate with that client's control
+// connection for the rest of its session.
+type Authenticator interface {
+	Authenticate(loginMsg *msg.Login) (*Principal, error)
+}
+
+// NewAuthenticator builds the Authenticator configured by the [auth]
+// section of the server config.
+func NewAuthenticator(cfg *g.ServerCommonConf) (Authenticator, error) {
+	switch strings.ToLower(cfg.AuthMethod) {
+	case "", "token":
+		return NewTokenAuth(cfg.Token, cfg.AuthTimeout), nil
+	case "jwt":
+		return NewJWTAuth(cfg.AuthJWTSecret, cfg.AuthJWTIssuer)
+	case "oidc":
+		return NewOIDCAuth(cfg.AuthOIDCIssuer, cfg.AuthOIDCJWKSUrl, cfg.AuthOIDCAudience)
+	default:
+		return nil, fmt.Errorf("unknown auth method [%s]", cfg.AuthMethod)
+	}
+}
+
+// TokenAuth is the original shared-token HMAC scheme: it verifies that
+// PrivilegeKey == util.GetAuthKey(token, timestamp) and that the login happened
+// within AuthTimeout seconds of the client signing it.
+type TokenAuth struct {
+	token       string
+	authTimeout int64
+}
+
+func NewTokenAuth(token string, authTimeout int64) *TokenAuth {
+	return &TokenAuth{
+		token:       token,
+		authTimeout: authTimeout,
+	}
+}
+
+func (auth *TokenAuth) Authenticate(loginMsg *msg.Login) (*Principal, error) {
+	nowTime := time.Now().Unix()
+	if auth.authTimeout != 0 && nowTime-loginMsg.Timestamp > auth.authTimeout {
+		return nil, fmt.Errorf("authorization timeout")
+	}
+	if util.GetAuthKey(auth.token, loginMsg.Timestamp) != loginMsg.PrivilegeKey {
+		return nil, fmt.Errorf("authorization failed")
+	}
+	return &Principal{Identity: "token"}, nil
+}
+
+// JWTAuth verifies HS256-signed bearer tokens carried in Login.PrivilegeKey
+// against a static secret, and maps registered ACL claims onto a Principal.
+type JWTAuth struct {
+	secret []byte
+	issuer string
+}
+
+func NewJWTAuth(secret, issuer string) (*JWTAuth, error) {
+	if secret == "" {
+		return nil, errors.New("auth.jwt_secret must be set when auth.method = jwt")
+	}
+	return &JWTAuth{secret: []byte(secret), issuer: issuer}, nil
+}
+
+type jwtClaims struct {
+	Subject            string   `json:"sub"`
+	Issuer             string   `json:"iss"`
+	ExpiresAt          int64    `json:"exp"`
+	AllowedPrefixes    []string `json:"frp_proxy_prefixes,omitempty"`
+	AllowedRemotePorts []int    `json:"frp_remote_ports,omitempty"`
+	Quota              int      `json:"frp_quota,omitempty"`
+}
+
+func (auth *JWTAuth) Authenticate(loginMsg *msg.Login) (*Principal, error) {
+	claims, err := verifyHS256(loginMsg.PrivilegeKey, auth.secret)
+	if err != nil {
+		return nil, fmt.Errorf("jwt verification failed: %v", err)
+	}
+	if auth.issuer != "" && claims.Issuer != auth.issuer {
+		return nil, fmt.Errorf("jwt issuer mismatch")
+	}
+	if claims.ExpiresAt != 0 && time.Now().Unix() > claims.ExpiresAt {
+		return nil, fmt.Errorf("jwt expired")
+	}
+	return &Principal{
+		Identity:                 claims.Subject,
+		AllowedProxyNamePrefixes: claims.AllowedPrefixes,
+		AllowedRemotePorts:       claims.AllowedRemotePorts,
+		Quota:                    claims.Quota,
+	}, nil
+}
+
+// verifyHS256 decodes and verifies a compact JWT using HMAC-SHA256.
+func verifyHS256(token string, secret []byte) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	expected := mac.Sum(nil)
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed signature")
+	}
+	if !hmac.Equal(expected, sig) {
+		return nil, errors.New("signature mismatch")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("malformed payload")
+	}
+	claims := &jwtClaims{}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, errors.New("malformed claims")
+	}
+	return claims, nil
+}
+
+// OIDCAuth verifies RS256-signed bearer tokens issued by an OIDC provider,
+// fetching and caching the provider's JWKS so key rotation is picked up
+// without a restart.
+type OIDCAuth struct {
+	issuer   string
+	audience string
+	jwks     *jwksCache
+}
+
+func NewOIDCAuth(issuer, jwksUrl, audience string) (*OIDCAuth, error) {
+	if jwksUrl == "" {
+		return nil, errors.New("auth.oidc_jwks_url must be set when auth.method = oidc")
+	}
+	return &OIDCAuth{
+		issuer:   issuer,
+		audience: audience,
+		jwks:     newJWKSCache(jwksUrl, 10*time.Minute),
+	}, nil
+}
+
+func (auth *OIDCAuth) Authenticate(loginMsg *msg.Login) (*Principal, error) {
+	parts := strings.Split(loginMsg.PrivilegeKey, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	header := struct {
+		Kid string `json:"kid"`
+	}{}
+	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.New("malformed header")
+	}
+	if err := json.Unmarshal(headerBytes, &header); err != nil {
+		return nil, errors.New("malformed header")
+	}
+
+	key, err := auth.jwks.Get(header.Kid)
+	if err != nil {
+		return nil, fmt.Errorf("fetch jwks: %v", err)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed signature")
+	}
+	hashed := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], sig); err != nil {
+		return nil, errors.New("signature mismatch")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("malformed payload")
+	}
+	claims := &jwtClaims{}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, errors.New("malformed claims")
+	}
+	if auth.issuer != "" && claims.Issuer != auth.issuer {
+		return nil, fmt.Errorf("oidc issuer mismatch")
+	}
+	if claims.ExpiresAt != 0 && time.Now().Unix() > claims.ExpiresAt {
+		return nil, fmt.Errorf("token expired")
+	}
+	return &Principal{
+		Identity:                 claims.Subject,
+		AllowedProxyNamePrefixes: claims.AllowedPrefixes,
+		AllowedRemotePorts:       claims.AllowedRemotePorts,
+		Quota:                    claims.Quota,
+	}, nil
+}
+
+// jwksCache fetches a JWKS document over HTTP and caches the decoded RSA
+// public keys by kid until ttl elapses, so rotation on the issuer's side is
+// picked up automatically. A kid that's still missing after a fresh fetch
+// is remembered for negativeTtl, so a client hammering logins with a bogus
+// or rotating kid can't force a refetch on every single attempt.
+type jwksCache struct {
+	url         string
+	ttl         time.Duration
+	negativeTtl time.Duration
+
+	mu         sync.Mutex
+	fetched    time.Time
+	keysByID   map[string]*rsa.PublicKey
+	missedKids map[string]time.Time
+}
+
+func newJWKSCache(url string, ttl time.Duration) *jwksCache {
+	return &jwksCache{
+		url:         url,
+		ttl:         ttl,
+		negativeTtl: 30 * time.Second,
+		keysByID:    make(map[string]*rsa.PublicKey),
+		missedKids:  make(map[string]time.Time),
+	}
+}
+
+// Get returns the public key for kid, fetching a fresh JWKS document if the
+// cache is stale. The fetch itself happens without holding mu, so a client
+// blocked on a slow or unreachable JWKS endpoint only ever stalls itself,
+// not every other login racing it for the same cache.
+func (c *jwksCache) Get(kid string) (*rsa.PublicKey, error) {
+	if key, fresh, negativelyCached := c.lookup(kid); fresh {
+		return key, nil
+	} else if negativelyCached {
+		return nil, fmt.Errorf("no matching key for kid [%s]", kid)
+	}
+
+	keys, err := fetchJWKS(c.url)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.keysByID = keys
+	c.fetched = time.Now()
+	c.missedKids = make(map[string]time.Time)
+
+	key, ok := keys[kid]
+	if !ok {
+		c.missedKids[kid] = time.Now()
+		return nil, fmt.Errorf("no matching key for kid [%s]", kid)
+	}
+	return key, nil
+}
+
+// lookup reports a fresh cache hit, or that kid was already confirmed
+// missing within negativeTtl, without ever reaching for the network.
+func (c *jwksCache) lookup(kid string) (key *rsa.PublicKey, fresh bool, negativelyCached bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if key, ok := c.keysByID[kid]; ok && time.Since(c.fetched) < c.ttl {
+		return key, true, false
+	}
+	if missedAt, ok := c.missedKids[kid]; ok && time.Since(missedAt) < c.negativeTtl {
+		return nil, false, true
+	}
+	return nil, false, false
+}
+
+type jwkSet struct {
+	Keys []struct {
+		Kid string `json:"kid"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	} `json:"keys"`
+}
+
+// fetchJWKS fetches and decodes the JWKS document at url. It touches no
+// cache state so callers are free to call it without holding any lock.
+func fetchJWKS(url string) (map[string]*rsa.PublicKey, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks endpoint returned status %d", resp.StatusCode)
+	}
+
+	var set jwkSet
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		nBytes, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		eBytes, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		e := 0
+		for _, b := range eBytes {
+			e = e<<8 | int(b)
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(nBytes),
+			E: e,
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,160 @@
+// Copyright 2017 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/fatedier/frp/models/msg"
+	"github.com/fatedier/frp/utils/util"
+)
+
+func signHS256(t *testing.T, claims jwtClaims, secret string) string {
+	header := `{"alg":"HS256","typ":"JWT"}`
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("marshal claims: %v", err)
+	}
+	segment := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." +
+		base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(segment))
+	return segment + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestTokenAuthSuccess(t *testing.T) {
+	auth := NewTokenAuth("s3cr3t", 15)
+	now := time.Now().Unix()
+	login := &msg.Login{
+		Timestamp:    now,
+		PrivilegeKey: util.GetAuthKey("s3cr3t", now),
+	}
+	if _, err := auth.Authenticate(login); err != nil {
+		t.Fatalf("expected success, got %v", err)
+	}
+}
+
+func TestTokenAuthExpired(t *testing.T) {
+	auth := NewTokenAuth("s3cr3t", 15)
+	old := time.Now().Unix() - 60
+	login := &msg.Login{
+		Timestamp:    old,
+		PrivilegeKey: util.GetAuthKey("s3cr3t", old),
+	}
+	if _, err := auth.Authenticate(login); err == nil {
+		t.Fatal("expected timeout error, got nil")
+	}
+}
+
+func TestTokenAuthBadSignature(t *testing.T) {
+	auth := NewTokenAuth("s3cr3t", 15)
+	now := time.Now().Unix()
+	login := &msg.Login{Timestamp: now, PrivilegeKey: "not-the-right-key"}
+	if _, err := auth.Authenticate(login); err == nil {
+		t.Fatal("expected signature error, got nil")
+	}
+}
+
+func TestJWTAuthSuccessWithAcl(t *testing.T) {
+	auth, err := NewJWTAuth("jwt-secret", "frps")
+	if err != nil {
+		t.Fatalf("new jwt auth: %v", err)
+	}
+	claims := jwtClaims{
+		Subject:            "alice",
+		Issuer:             "frps",
+		ExpiresAt:          time.Now().Add(time.Hour).Unix(),
+		AllowedPrefixes:    []string{"alice-"},
+		AllowedRemotePorts: []int{6000},
+	}
+	login := &msg.Login{PrivilegeKey: signHS256(t, claims, "jwt-secret")}
+
+	principal, err := auth.Authenticate(login)
+	if err != nil {
+		t.Fatalf("expected success, got %v", err)
+	}
+	if !principal.AllowProxyName("alice-web") {
+		t.Error("expected alice-web to be permitted")
+	}
+	if principal.AllowProxyName("bob-web") {
+		t.Error("expected bob-web to be denied")
+	}
+	if !principal.AllowRemotePort(6000) {
+		t.Error("expected port 6000 to be permitted")
+	}
+	if principal.AllowRemotePort(7000) {
+		t.Error("expected port 7000 to be denied")
+	}
+}
+
+func TestJWTAuthExpired(t *testing.T) {
+	auth, err := NewJWTAuth("jwt-secret", "")
+	if err != nil {
+		t.Fatalf("new jwt auth: %v", err)
+	}
+	claims := jwtClaims{Subject: "alice", ExpiresAt: time.Now().Add(-time.Minute).Unix()}
+	login := &msg.Login{PrivilegeKey: signHS256(t, claims, "jwt-secret")}
+
+	if _, err := auth.Authenticate(login); err == nil {
+		t.Fatal("expected expiry error, got nil")
+	}
+}
+
+func TestJWTAuthBadSignature(t *testing.T) {
+	auth, err := NewJWTAuth("jwt-secret", "")
+	if err != nil {
+		t.Fatalf("new jwt auth: %v", err)
+	}
+	claims := jwtClaims{Subject: "alice", ExpiresAt: time.Now().Add(time.Hour).Unix()}
+	login := &msg.Login{PrivilegeKey: signHS256(t, claims, "wrong-secret")}
+
+	if _, err := auth.Authenticate(login); err == nil {
+		t.Fatal("expected signature error, got nil")
+	}
+}
+
+func TestCheckProxyAclDenial(t *testing.T) {
+	principal := &Principal{AllowedProxyNamePrefixes: []string{"alice-"}, AllowedRemotePorts: []int{6000}}
+
+	if err := CheckProxyAcl(principal, "alice-web", 6000); err != nil {
+		t.Errorf("expected allowed, got %v", err)
+	}
+	if err := CheckProxyAcl(principal, "bob-web", 6000); err == nil {
+		t.Error("expected name denial, got nil")
+	}
+	if err := CheckProxyAcl(principal, "alice-web", 7000); err == nil {
+		t.Error("expected port denial, got nil")
+	}
+}
+
+// TestRegisterProxyRejectsAclDenial exercises the real Service.RegisterProxy
+// entry point (not just the CheckProxyAcl helper) to make sure a denied
+// client is actually turned away before ever reaching pxyManager.Add: pxy
+// is left nil, so the test would panic on any code path that dereferences
+// it instead of returning the ACL error first.
+func TestRegisterProxyRejectsAclDenial(t *testing.T) {
+	svr := &Service{pxyManager: NewProxyManager(), proxyQuota: newProxyQuota()}
+	principal := &Principal{AllowedProxyNamePrefixes: []string{"alice-"}}
+
+	err := svr.RegisterProxy("bob-web", nil, principal, "tcp", 0)
+	if err == nil {
+		t.Fatal("expected registration to be denied by ACL, got nil error")
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2017 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fatedier/frp/g"
+	"github.com/fatedier/frp/utils/log"
+)
+
+// Event types emitted on the configured [events] sink.
+const (
+	EventClientLogin  = "client_login"
+	EventClientLogout = "client_logout"
+	EventProxyAdd     = "proxy_add"
+	EventProxyRemove  = "proxy_remove"
+	EventConnOpen     = "conn_open"
+	EventConnClose    = "conn_close"
+)
+
+// Event is a single newline-delimited JSON record written to the events
+// file sink and/or POSTed to the events webhook.
+type Event struct {
+	Type      string                 `json:"type"`
+	Time      time.Time              `json:"time"`
+	RunId     string                 `json:"run_id,omitempty"`
+	ProxyName string                 `json:"proxy_name,omitempty"`
+	Detail    map[string]interface{} `json:"detail,omitempty"`
+}
+
+// eventSink fans events out to an optional file and/or webhook as
+// configured under [events]. A nil *eventSink silently drops events so
+// call sites never need to nil-check it.
+type eventSink struct {
+	file   *os.File
+	hook   string
+	client *http.Client
+
+	mu sync.Mutex
+}
+
+var globalEventSink *eventSink
+
+// NewEventSink builds the sink described by the server's [events] config.
+// Either field may be empty, in which case that output is disabled.
+func NewEventSink(filePath, webhookUrl string) (*eventSink, error) {
+	sink := &eventSink{hook: webhookUrl, client: &http.Client{Timeout: 5 * time.Second}}
+	if filePath != "" {
+		f, err := os.OpenFile(filePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		sink.file = f
+	}
+	return sink, nil
+}
+
+// Emit records ev to every configured output. Failures are logged, never
+// returned, since event delivery must never block the data path.
+func (s *eventSink) Emit(ev Event) {
+	if s == nil {
+		return
+	}
+	ev.Time = time.Now()
+	buf, err := json.Marshal(ev)
+	if err != nil {
+		log.Warn("marshal event [%s] error: %v", ev.Type, err)
+		return
+	}
+
+	s.mu.Lock()
+	if s.file != nil {
+		if _, err := s.file.Write(append(buf, '\n')); err != nil {
+			log.Warn("write event [%s] to file error: %v", ev.Type, err)
+		}
+	}
+	s.mu.Unlock()
+
+	if s.hook != "" {
+		go func() {
+			resp, err := s.client.Post(s.hook, "application/json", bytes.NewReader(buf))
+			if err != nil {
+				log.Warn("post event [%s] to webhook error: %v", ev.Type, err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+}
+
+// emitEvent is a package-level convenience so call sites in service.go
+// don't need to thread the sink through every function.
+func emitEvent(ev Event) {
+	globalEventSink.Emit(ev)
+}
+
+func initEventSink(cfg *g.ServerCommonConf) error {
+	sink, err := NewEventSink(cfg.EventsFile, cfg.EventsWebhookUrl)
+	if err != nil {
+		return err
+	}
+	globalEventSink = sink
+	return nil
+}
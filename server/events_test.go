@@ -0,0 +1,63 @@
+// Copyright 2017 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEventSinkWritesNdjsonToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	sink, err := NewEventSink(path, "")
+	if err != nil {
+		t.Fatalf("new event sink: %v", err)
+	}
+
+	sink.Emit(Event{Type: EventProxyAdd, ProxyName: "web"})
+	sink.Emit(Event{Type: EventProxyRemove, ProxyName: "web"})
+	sink.file.Close()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open events file: %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 event lines, got %d", len(lines))
+	}
+
+	var ev Event
+	if err := json.Unmarshal([]byte(lines[0]), &ev); err != nil {
+		t.Fatalf("unmarshal event: %v", err)
+	}
+	if ev.Type != EventProxyAdd || ev.ProxyName != "web" {
+		t.Errorf("unexpected event: %+v", ev)
+	}
+}
+
+func TestNilEventSinkEmitIsNoop(t *testing.T) {
+	var sink *eventSink
+	sink.Emit(Event{Type: EventConnOpen})
+}
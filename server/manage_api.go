@@ -0,0 +1,211 @@
+// Copyright 2017 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/fatedier/frp/g"
+	"github.com/fatedier/frp/utils/log"
+)
+
+// ManageApi serves the operator-facing management endpoints registered on
+// the dashboard listener: reloading parts of the server config without a
+// restart, pre-provisioning proxies, and force-disconnecting a client.
+// The reload handler and the provision write paths take mu so a reload
+// can't race a provision write.
+type ManageApi struct {
+	svr   *Service
+	store *ProvisionStore
+
+	mu sync.Mutex
+}
+
+func NewManageApi(svr *Service, store *ProvisionStore) *ManageApi {
+	return &ManageApi{svr: svr, store: store}
+}
+
+// RegisterRoutes wires the management endpoints onto mux, which is expected
+// to be the dashboard's http.ServeMux. When ManageApiToken is set, every
+// route requires it as a bearer token (see requireToken): it can reload security-relevant
+// config, provision proxies, and force-disconnect any client, so it gets
+// its own access control independent of the dashboard's basic auth.
+func (m *ManageApi) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/api/reload", m.requireToken(m.handleReload))
+	mux.HandleFunc("/api/proxies/provision", m.requireToken(m.handleProvision))
+	mux.HandleFunc("/api/clients/disconnect", m.requireToken(m.handleDisconnect))
+}
+
+// requireToken rejects requests that don't present ManageApiToken as a
+// bearer token, unless no token is configured, in which case the api is
+// left open (the operator is expected to put it behind the dashboard's own
+// auth or a reverse proxy in that case).
+func (m *ManageApi) requireToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := g.GlbServerCfg.ManageApiToken
+		if token == "" {
+			next(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// reloadableConf is the subset of ServerCommonConf that can change without
+// restarting the process.
+type reloadableConf struct {
+	AllowPorts    []int  `json:"allow_ports,omitempty"`
+	SubDomainHost string `json:"subdomain_host,omitempty"`
+
+	// Auth reload rebuilds svr.authenticator; AuthMethod is required
+	// whenever any of the other Auth* fields are set.
+	AuthMethod       string `json:"auth_method,omitempty"`
+	AuthJWTSecret    string `json:"auth_jwt_secret,omitempty"`
+	AuthJWTIssuer    string `json:"auth_jwt_issuer,omitempty"`
+	AuthOIDCIssuer   string `json:"auth_oidc_issuer,omitempty"`
+	AuthOIDCJWKSUrl  string `json:"auth_oidc_jwks_url,omitempty"`
+	AuthOIDCAudience string `json:"auth_oidc_audience,omitempty"`
+}
+
+func (m *ManageApi) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var body reloadableConf
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.AuthMethod == "" && (body.AuthJWTSecret != "" || body.AuthJWTIssuer != "" ||
+		body.AuthOIDCIssuer != "" || body.AuthOIDCJWKSUrl != "" || body.AuthOIDCAudience != "") {
+		http.Error(w, "auth_method is required when other auth fields are set", http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cfg := &g.GlbServerCfg.ServerCommonConf
+	if len(body.AllowPorts) > 0 {
+		cfg.AllowPorts = make(map[int]struct{}, len(body.AllowPorts))
+		for _, p := range body.AllowPorts {
+			cfg.AllowPorts[p] = struct{}{}
+		}
+		// Mutate the existing PortManagers' allow-set in place instead of
+		// swapping in new ones, so ports already bound by connected
+		// clients are never forgotten mid-reload.
+		m.svr.tcpPortManager.UpdateAllowPorts(cfg.AllowPorts)
+		m.svr.udpPortManager.UpdateAllowPorts(cfg.AllowPorts)
+	}
+	if body.SubDomainHost != "" {
+		cfg.SubDomainHost = body.SubDomainHost
+	}
+	if body.AuthMethod != "" {
+		cfg.AuthMethod = body.AuthMethod
+		cfg.AuthJWTSecret = body.AuthJWTSecret
+		cfg.AuthJWTIssuer = body.AuthJWTIssuer
+		cfg.AuthOIDCIssuer = body.AuthOIDCIssuer
+		cfg.AuthOIDCJWKSUrl = body.AuthOIDCJWKSUrl
+		cfg.AuthOIDCAudience = body.AuthOIDCAudience
+
+		authenticator, err := NewAuthenticator(cfg)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("rebuild authenticator: %v", err), http.StatusBadRequest)
+			return
+		}
+		m.svr.setAuthenticator(authenticator)
+	}
+
+	log.Info("server config reloaded via management api")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (m *ManageApi) handleProvision(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var p ProvisionedProxy
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if p.Name == "" {
+			http.Error(w, "name is required", http.StatusBadRequest)
+			return
+		}
+
+		m.mu.Lock()
+		err := m.store.Put(&p)
+		m.mu.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Info("proxy [%s] provisioned via management api", p.Name)
+		w.WriteHeader(http.StatusOK)
+	case http.MethodDelete:
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			http.Error(w, "name is required", http.StatusBadRequest)
+			return
+		}
+
+		m.mu.Lock()
+		err := m.store.Delete(name)
+		m.mu.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(m.store.List())
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+type disconnectRequest struct {
+	RunId string `json:"run_id"`
+}
+
+func (m *ManageApi) handleDisconnect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var body disconnectRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctl, exist := m.svr.ctlManager.GetById(body.RunId)
+	if !exist {
+		http.Error(w, "no such client", http.StatusNotFound)
+		return
+	}
+	ctl.Close()
+	m.svr.CloseControl(body.RunId)
+	log.Info("client [%s] force-disconnected via management api", body.RunId)
+	w.WriteHeader(http.StatusOK)
+}
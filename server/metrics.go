@@ -0,0 +1,122 @@
+// Copyright 2017 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/fatedier/frp/utils/log"
+)
+
+// metrics holds the Prometheus collectors fed from the hot paths in
+// service.go. It is safe for concurrent use, since
+// every field is itself a prometheus.Collector guarding its own state.
+type metrics struct {
+	ClientCount       prometheus.Gauge
+	ProxyCount        *prometheus.GaugeVec
+	ActiveConnections prometheus.Gauge
+	BytesIn           *prometheus.CounterVec
+	BytesOut          *prometheus.CounterVec
+	HandshakeLatency  prometheus.Histogram
+	AuthFailures      prometheus.Counter
+	MuxStreamErrors   prometheus.Counter
+
+	handler http.Handler
+}
+
+var frpsMetrics = newMetrics()
+
+func newMetrics() *metrics {
+	m := &metrics{
+		ClientCount: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "frp",
+			Subsystem: "server",
+			Name:      "client_count",
+			Help:      "Number of currently connected clients.",
+		}),
+		ProxyCount: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "frp",
+			Subsystem: "server",
+			Name:      "proxy_count",
+			Help:      "Number of currently registered proxies, by proxy type.",
+		}, []string{"type"}),
+		ActiveConnections: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "frp",
+			Subsystem: "server",
+			Name:      "active_connections",
+			Help:      "Number of currently open proxied connections.",
+		}),
+		BytesIn: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "frp",
+			Subsystem: "server",
+			Name:      "bytes_in_total",
+			Help:      "Total bytes read from clients, by proxy name.",
+		}, []string{"proxy"}),
+		BytesOut: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "frp",
+			Subsystem: "server",
+			Name:      "bytes_out_total",
+			Help:      "Total bytes written to clients, by proxy name.",
+		}, []string{"proxy"}),
+		HandshakeLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "frp",
+			Subsystem: "server",
+			Name:      "handshake_latency_seconds",
+			Help:      "Time from accepting a control connection to a successful login.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		AuthFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "frp",
+			Subsystem: "server",
+			Name:      "auth_failures_total",
+			Help:      "Total number of failed client logins and work connections presenting an unknown run id.",
+		}),
+		MuxStreamErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "frp",
+			Subsystem: "server",
+			Name:      "mux_stream_errors_total",
+			Help:      "Total number of errors accepting yamux or QUIC streams on a control connection.",
+		}),
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(m.ClientCount, m.ProxyCount, m.ActiveConnections,
+		m.BytesIn, m.BytesOut, m.HandshakeLatency, m.AuthFailures, m.MuxStreamErrors)
+	m.handler = promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	return m
+}
+
+// ServeMetrics registers the /metrics endpoint on mux, which is expected to
+// be the dashboard's http.ServeMux.
+func (m *metrics) ServeMetrics(mux *http.ServeMux, path string) {
+	mux.Handle(path, m.handler)
+	log.Info("prometheus metrics exposed on %s", path)
+}
+
+// RecordProxyTraffic adds to the per-proxy BytesIn/BytesOut counters, for
+// use once the copy in each direction finishes and the byte count is known.
+// The proxies' work-connection copy loops do not call it, so BytesIn and
+// BytesOut stay at zero.
+func RecordProxyTraffic(name string, bytesIn, bytesOut int64) {
+	if bytesIn > 0 {
+		frpsMetrics.BytesIn.WithLabelValues(name).Add(float64(bytesIn))
+	}
+	if bytesOut > 0 {
+		frpsMetrics.BytesOut.WithLabelValues(name).Add(float64(bytesOut))
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2017 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"sync"
+)
+
+// PortManager hands out remote ports for tcp/udp proxies, constrained to an
+// operator-configured allow-set. An empty allow-set means every port is
+// allowed. UpdateAllowPorts lets the management api reload the allow-set in
+// place, so ports already held by connected clients are never forgotten
+// mid-reload the way swapping in a whole new *PortManager would forget
+// them.
+type PortManager struct {
+	proto      string
+	bindAddr   string
+	allowPorts map[int]struct{}
+	usedPorts  map[int]struct{}
+
+	mu sync.Mutex
+}
+
+func NewPortManager(proto string, bindAddr string, allowPorts map[int]struct{}) *PortManager {
+	return &PortManager{
+		proto:      proto,
+		bindAddr:   bindAddr,
+		allowPorts: allowPorts,
+		usedPorts:  make(map[int]struct{}),
+	}
+}
+
+// Acquire reserves port for a proxy, checking it against the allow-set and
+// against ports already held by other proxies. port must be non-zero; proxy
+// types without a remote port (http/https vhost proxies) never call this.
+func (pm *PortManager) Acquire(name string, port int) error {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	if len(pm.allowPorts) > 0 {
+		if _, ok := pm.allowPorts[port]; !ok {
+			return fmt.Errorf("%s port [%d] is not in the allowed port set", pm.proto, port)
+		}
+	}
+	if _, ok := pm.usedPorts[port]; ok {
+		return fmt.Errorf("%s port [%d] is already in use", pm.proto, port)
+	}
+	pm.usedPorts[port] = struct{}{}
+	return nil
+}
+
+// Release frees port so a later Acquire can reuse it.
+func (pm *PortManager) Release(port int) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	delete(pm.usedPorts, port)
+}
+
+// UpdateAllowPorts replaces the allow-set without touching usedPorts, so a
+// reload can never strand or silently disconnect a proxy that is already
+// bound to a port the new allow-set happens to drop; it only affects
+// Acquire calls from that point on.
+func (pm *PortManager) UpdateAllowPorts(allowPorts map[int]struct{}) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.allowPorts = allowPorts
+}
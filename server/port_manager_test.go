@@ -0,0 +1,55 @@
+// Copyright 2017 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import "testing"
+
+func TestPortManagerRejectsPortOutsideAllowSet(t *testing.T) {
+	pm := NewPortManager("tcp", "0.0.0.0", map[int]struct{}{6000: {}})
+	if err := pm.Acquire("web", 7000); err == nil {
+		t.Fatal("expected port outside allow-set to be rejected")
+	}
+}
+
+func TestPortManagerRejectsDoubleAcquire(t *testing.T) {
+	pm := NewPortManager("tcp", "0.0.0.0", nil)
+	if err := pm.Acquire("web", 6000); err != nil {
+		t.Fatalf("expected first acquire to succeed, got %v", err)
+	}
+	if err := pm.Acquire("web2", 6000); err == nil {
+		t.Fatal("expected second acquire of the same port to be rejected")
+	}
+}
+
+func TestPortManagerUpdateAllowPortsPreservesExistingAllocations(t *testing.T) {
+	pm := NewPortManager("tcp", "0.0.0.0", map[int]struct{}{6000: {}})
+	if err := pm.Acquire("web", 6000); err != nil {
+		t.Fatalf("expected acquire to succeed, got %v", err)
+	}
+
+	// Dropping 6000 from the allow-set must not evict the existing holder.
+	pm.UpdateAllowPorts(map[int]struct{}{7000: {}})
+	if err := pm.Acquire("web2", 6000); err == nil {
+		t.Fatal("expected 6000 to be rejected once dropped from the allow-set")
+	}
+	if _, ok := pm.usedPorts[6000]; !ok {
+		t.Fatal("expected 6000 to remain reserved by the first holder")
+	}
+
+	pm.Release(6000)
+	if err := pm.Acquire("web2", 7000); err != nil {
+		t.Fatalf("expected newly allowed port to be acquirable, got %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2017 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// ProvisionedProxy is a proxy an operator has pre-registered through the
+// management API, before any client has connected to claim it. It persists
+// across client reconnects and server restarts.
+type ProvisionedProxy struct {
+	Name           string   `json:"name"`
+	Type           string   `json:"type"`
+	AllowedClients []string `json:"allowed_clients,omitempty"`
+	BindPort       int      `json:"bind_port,omitempty"`
+	CustomDomains  []string `json:"custom_domains,omitempty"`
+}
+
+// ProvisionStore persists provisioned proxies to a JSON file on disk so
+// they survive a server restart. It is intentionally simple: the set of
+// provisioned proxies is small and changes rarely, so a full file rewrite
+// under a mutex is cheap enough and avoids taking on a database dependency.
+type ProvisionStore struct {
+	path string
+
+	mu      sync.Mutex
+	proxies map[string]*ProvisionedProxy
+}
+
+func NewProvisionStore(path string) (*ProvisionStore, error) {
+	s := &ProvisionStore{path: path, proxies: make(map[string]*ProvisionedProxy)}
+	if path == "" {
+		return s, nil
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *ProvisionStore) load() error {
+	buf, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var list []*ProvisionedProxy
+	if err := json.Unmarshal(buf, &list); err != nil {
+		return err
+	}
+	for _, p := range list {
+		s.proxies[p.Name] = p
+	}
+	return nil
+}
+
+func (s *ProvisionStore) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	list := make([]*ProvisionedProxy, 0, len(s.proxies))
+	for _, p := range s.proxies {
+		list = append(list, p)
+	}
+	buf, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.path, buf, 0644)
+}
+
+// Put adds or replaces a provisioned proxy and persists the store.
+func (s *ProvisionStore) Put(p *ProvisionedProxy) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.proxies[p.Name] = p
+	return s.saveLocked()
+}
+
+// Delete removes a provisioned proxy by name and persists the store.
+func (s *ProvisionStore) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.proxies, name)
+	return s.saveLocked()
+}
+
+// List returns a snapshot of all currently provisioned proxies.
+func (s *ProvisionStore) List() []*ProvisionedProxy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]*ProvisionedProxy, 0, len(s.proxies))
+	for _, p := range s.proxies {
+		list = append(list, p)
+	}
+	return list
+}
+
+// Get returns the provisioned proxy registered under name, if any.
+func (s *ProvisionStore) Get(name string) (*ProvisionedProxy, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.proxies[name]
+	return p, ok
+}
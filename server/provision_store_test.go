@@ -0,0 +1,58 @@
+// Copyright 2017 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestProvisionStorePersistsAcrossReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxies.json")
+
+	store, err := NewProvisionStore(path)
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	if err := store.Put(&ProvisionedProxy{Name: "web", Type: "http", BindPort: 8080}); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	reloaded, err := NewProvisionStore(path)
+	if err != nil {
+		t.Fatalf("reload store: %v", err)
+	}
+	p, ok := reloaded.Get("web")
+	if !ok {
+		t.Fatal("expected web proxy to survive reload")
+	}
+	if p.BindPort != 8080 {
+		t.Errorf("expected bind port 8080, got %d", p.BindPort)
+	}
+}
+
+func TestProvisionStoreDelete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxies.json")
+	store, err := NewProvisionStore(path)
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	store.Put(&ProvisionedProxy{Name: "web"})
+	store.Delete("web")
+
+	if _, ok := store.Get("web"); ok {
+		t.Fatal("expected web proxy to be gone after delete")
+	}
+}
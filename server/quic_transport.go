@@ -0,0 +1,182 @@
+// Copyright 2017 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+
+	quic "github.com/lucas-clemente/quic-go"
+
+	"github.com/fatedier/frp/utils/log"
+	frpNet "github.com/fatedier/frp/utils/net"
+)
+
+// quicControlStreamTimeout bounds how long a QUIC session may take to open
+// its control stream before we give up on it.
+const quicControlStreamTimeout = 10 * time.Second
+
+// quicTlsConfig returns the TLS config QUIC requires to set up its
+// transport. frp's own message layer already carries the real
+// authentication and (optionally) payload encryption, so an ephemeral,
+// self-signed certificate is sufficient here; it is not used to establish
+// client trust.
+func quicTlsConfig() *tls.Config {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(fmt.Sprintf("generate quic tls key: %v", err))
+	}
+	template := x509.Certificate{SerialNumber: big.NewInt(1)}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		panic(fmt.Sprintf("create quic tls cert: %v", err))
+	}
+	cert := tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"frp"},
+	}
+}
+
+// streamAccepter is implemented by frpNet.Conns that, like a yamux session,
+// can hand out further logical connections multiplexed over the same
+// transport. HandleListener uses it to treat QUIC sessions the same way it
+// already treats yamux-muxed TCP connections.
+type streamAccepter interface {
+	AcceptStream() (frpNet.Conn, error)
+}
+
+// NewQuicListener listens for QUIC connections on bindAddr:bindPort. The
+// returned frpNet.Listener hands out one frpNet.Conn per accepted session,
+// the same way a TCP or KCP listener does: the session's first stream carries the control
+// handshake, and any additional streams the client opens are picked up via
+// the returned conn's AcceptStream, mirroring yamux's AcceptStream.
+// acceptSessions only takes the session off the QUIC listener and leaves
+// waiting on that first stream to acceptControlStream, running in its own
+// goroutine per session, so a session that's open but idle can't hold up
+// every other client's Accept.
+func NewQuicListener(bindAddr string, bindPort int, tlsConf *tls.Config) (frpNet.Listener, error) {
+	addr := fmt.Sprintf("%s:%d", bindAddr, bindPort)
+	ql, err := quic.ListenAddr(addr, tlsConf, &quic.Config{KeepAlive: true})
+	if err != nil {
+		return nil, err
+	}
+	l := &quicListener{
+		ql:     ql,
+		accept: make(chan frpNet.Conn),
+		closed: make(chan struct{}),
+	}
+	go l.acceptSessions()
+	return l, nil
+}
+
+type quicListener struct {
+	ql     quic.Listener
+	accept chan frpNet.Conn
+	closed chan struct{}
+}
+
+// acceptSessions only does the cheap part of Accept: taking the next QUIC
+// session off the listener. The expensive part, waiting for that session's
+// first stream, happens in its own goroutine per session.
+func (l *quicListener) acceptSessions() {
+	for {
+		sess, err := l.ql.Accept(context.Background())
+		if err != nil {
+			close(l.accept)
+			return
+		}
+		go l.acceptControlStream(sess)
+	}
+}
+
+func (l *quicListener) acceptControlStream(sess quic.Session) {
+	ctx, cancel := context.WithTimeout(context.Background(), quicControlStreamTimeout)
+	defer cancel()
+
+	// The listener is opened with quic.ListenAddr, which does not accept
+	// 0-RTT data, so a reconnecting client completes a full handshake. Each
+	// session opens a fresh control stream so RegisterControl runs exactly
+	// once per login.
+	stream, err := sess.AcceptStream(ctx)
+	if err != nil {
+		log.Warn("quic session from [%s] never opened a control stream: %v", sess.RemoteAddr(), err)
+		sess.CloseWithError(quic.ApplicationErrorCode(0), "control stream accept failed")
+		return
+	}
+
+	conn := &quicConn{
+		Conn: frpNet.WrapConn(&quicStream{Stream: stream, sess: sess}),
+		sess: sess,
+	}
+	select {
+	case l.accept <- conn:
+	case <-l.closed:
+		conn.Close()
+	}
+}
+
+func (l *quicListener) Accept() (frpNet.Conn, error) {
+	conn, ok := <-l.accept
+	if !ok {
+		return nil, fmt.Errorf("quic listener closed")
+	}
+	return conn, nil
+}
+
+func (l *quicListener) Close() error {
+	close(l.closed)
+	return l.ql.Close()
+}
+
+func (l *quicListener) Addr() net.Addr {
+	return l.ql.Addr()
+}
+
+// quicConn is a frpNet.Conn backed by one stream of a QUIC session, with
+// the session kept alongside so additional streams can be accepted later.
+type quicConn struct {
+	frpNet.Conn
+	sess quic.Session
+}
+
+func (c *quicConn) AcceptStream() (frpNet.Conn, error) {
+	stream, err := c.sess.AcceptStream(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return frpNet.WrapConn(&quicStream{Stream: stream, sess: c.sess}), nil
+}
+
+// quicStream adapts a quic.Stream (which has no notion of local/remote
+// address) into a net.Conn by borrowing the addresses from its session, so
+// it can be passed to frpNet.WrapConn like any other net.Conn.
+type quicStream struct {
+	quic.Stream
+	sess quic.Session
+}
+
+func (s *quicStream) LocalAddr() net.Addr  { return s.sess.LocalAddr() }
+func (s *quicStream) RemoteAddr() net.Addr { return s.sess.RemoteAddr() }
@@ -19,6 +19,8 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fatedier/frp/assets"
@@ -51,6 +53,15 @@ type Service struct {
 	// Accept connections using kcp.
 	kcpListener frpNet.Listener
 
+	// Accept connections using quic, giving clients per-stream flow control
+	// instead of TCP+yamux's head-of-line blocking. 0-RTT data is not
+	// accepted (see NewQuicListener).
+	quicListener frpNet.Listener
+
+	// Accept mTLS-wrapped connections; a client presenting a certificate
+	// trusted by cfg.MtlsCaFile is authenticated by the handshake itself.
+	tlsListener frpNet.Listener
+
 	// For https proxies, route requests to different clients by hostname and other infomation.
 	VhostHttpsMuxer *vhost.HttpsMuxer
 
@@ -62,6 +73,9 @@ type Service struct {
 	// Manage all proxies.
 	pxyManager *ProxyManager
 
+	// Tracks each principal's live proxy count against its quota.
+	proxyQuota *proxyQuota
+
 	// Manage all visitor listeners.
 	visitorManager *VisitorManager
 
@@ -73,17 +87,42 @@ type Service struct {
 
 	// Controller for nat hole connections.
 	natHoleController *NatHoleController
+
+	// Verifies client logins and returns the Principal enforced against
+	// that client's proxies and ports for the life of its control conn.
+	// Stored in an atomic.Value (holding an Authenticator) rather than a
+	// plain field because ManageApi.handleReload can replace it from a
+	// different goroutine while RegisterControl is reading it for an
+	// in-flight login.
+	authenticator atomic.Value
+
+	// Proxies pre-provisioned through the management API, persisted across
+	// client reconnects and server restarts.
+	provisionStore *ProvisionStore
 }
 
 func NewService() (svr *Service, err error) {
 	cfg := &g.GlbServerCfg.ServerCommonConf
+	authenticator, err := NewAuthenticator(cfg)
+	if err != nil {
+		err = fmt.Errorf("build authenticator error: %v", err)
+		return
+	}
+	provisionStore, err := NewProvisionStore(cfg.ProvisionStorePath)
+	if err != nil {
+		err = fmt.Errorf("load provision store error: %v", err)
+		return
+	}
 	svr = &Service{
 		ctlManager:     NewControlManager(),
 		pxyManager:     NewProxyManager(),
+		proxyQuota:     newProxyQuota(),
 		visitorManager: NewVisitorManager(),
 		tcpPortManager: NewPortManager("tcp", cfg.ProxyBindAddr, cfg.AllowPorts),
 		udpPortManager: NewPortManager("udp", cfg.ProxyBindAddr, cfg.AllowPorts),
+		provisionStore: provisionStore,
 	}
+	svr.setAuthenticator(authenticator)
 
 	// Init assets.
 	err = assets.Load(cfg.AssetsDir)
@@ -95,6 +134,7 @@ func NewService() (svr *Service, err error) {
 	var (
 		httpMuxOn  bool
 		httpsMuxOn bool
+		mtlsMuxOn  bool
 	)
 	if cfg.BindAddr == cfg.ProxyBindAddr {
 		if cfg.BindPort == cfg.VhostHttpPort {
@@ -103,9 +143,12 @@ func NewService() (svr *Service, err error) {
 		if cfg.BindPort == cfg.VhostHttpsPort {
 			httpsMuxOn = true
 		}
-		if httpMuxOn || httpsMuxOn {
-			svr.muxer = mux.NewMux()
-		}
+	}
+	if cfg.MtlsEnable && cfg.BindPort == cfg.MtlsBindPort {
+		mtlsMuxOn = true
+	}
+	if httpMuxOn || httpsMuxOn || mtlsMuxOn {
+		svr.muxer = mux.NewMux()
 	}
 
 	// Listen for accepting connections from client.
@@ -121,6 +164,33 @@ func NewService() (svr *Service, err error) {
 	svr.listener = frpNet.WrapLogListener(ln)
 	log.Info("frps tcp listen on %s:%d", cfg.BindAddr, cfg.BindPort)
 
+	// Wrap a TLS-peeked sub-listener so a client presenting a trusted
+	// certificate is authenticated by the handshake itself, and so the
+	// same port can serve plain frp, HTTPS vhost, and TLS-wrapped frp.
+	if cfg.MtlsEnable {
+		tlsConf, tlsErr := tlsServerConfig(cfg.MtlsCertFile, cfg.MtlsKeyFile, cfg.MtlsCaFile)
+		if tlsErr != nil {
+			err = fmt.Errorf("build mtls config error: %v", tlsErr)
+			return
+		}
+		var tlsRawLn net.Listener
+		if mtlsMuxOn {
+			tlsRawLn = svr.muxer.ListenTls(0)
+		} else {
+			tlsRawLn, err = net.Listen("tcp", fmt.Sprintf("%s:%d", cfg.BindAddr, cfg.MtlsBindPort))
+			if err != nil {
+				err = fmt.Errorf("Create mtls listener error, %v", err)
+				return
+			}
+		}
+		svr.tlsListener = NewTlsListener(tlsRawLn, tlsConf)
+		if mtlsMuxOn {
+			log.Info("frps mtls multiplexed on %s:%d", cfg.BindAddr, cfg.BindPort)
+		} else {
+			log.Info("frps mtls listen on %s:%d", cfg.BindAddr, cfg.MtlsBindPort)
+		}
+	}
+
 	// Listen for accepting connections from client using kcp protocol.
 	if cfg.KcpBindPort > 0 {
 		svr.kcpListener, err = frpNet.ListenKcp(cfg.BindAddr, cfg.KcpBindPort)
@@ -131,6 +201,16 @@ func NewService() (svr *Service, err error) {
 		log.Info("frps kcp listen on udp %s:%d", cfg.BindAddr, cfg.KcpBindPort)
 	}
 
+	// Listen for accepting connections from client using quic protocol.
+	if cfg.QuicBindPort > 0 {
+		svr.quicListener, err = NewQuicListener(cfg.BindAddr, cfg.QuicBindPort, quicTlsConfig())
+		if err != nil {
+			err = fmt.Errorf("Listen on quic address udp [%s:%d] error: %v", cfg.BindAddr, cfg.QuicBindPort, err)
+			return
+		}
+		log.Info("frps quic listen on udp %s:%d", cfg.BindAddr, cfg.QuicBindPort)
+	}
+
 	// Create http vhost muxer.
 	if cfg.VhostHttpPort > 0 {
 		rp := vhost.NewHttpReverseProxy()
@@ -191,13 +271,27 @@ func NewService() (svr *Service, err error) {
 
 	// Create dashboard web server.
 	if cfg.DashboardPort > 0 {
-		err = RunDashboardServer(cfg.DashboardAddr, cfg.DashboardPort)
+		extraRoutes := make(map[string]http.Handler)
+		if cfg.MetricsEnable {
+			extraRoutes[cfg.MetricsPath] = frpsMetrics.handler
+		}
+		if cfg.ManageApiEnable {
+			manageMux := http.NewServeMux()
+			NewManageApi(svr, svr.provisionStore).RegisterRoutes(manageMux)
+			extraRoutes["/api/"] = manageMux
+		}
+		err = RunDashboardServer(cfg.DashboardAddr, cfg.DashboardPort, extraRoutes)
 		if err != nil {
 			err = fmt.Errorf("Create dashboard web server error, %v", err)
 			return
 		}
 		log.Info("Dashboard listen on %s:%d", cfg.DashboardAddr, cfg.DashboardPort)
 	}
+
+	if err = initEventSink(cfg); err != nil {
+		err = fmt.Errorf("init event sink error: %v", err)
+		return
+	}
 	return
 }
 
@@ -208,10 +302,29 @@ func (svr *Service) Run() {
 	if g.GlbServerCfg.KcpBindPort > 0 {
 		go svr.HandleListener(svr.kcpListener)
 	}
+	if g.GlbServerCfg.QuicBindPort > 0 {
+		go svr.HandleListener(svr.quicListener)
+	}
+	if svr.tlsListener != nil {
+		go svr.HandleListener(svr.tlsListener)
+	}
 	svr.HandleListener(svr.listener)
 
 }
 
+// Authenticator returns the Authenticator currently in effect. Safe to
+// call concurrently with setAuthenticator.
+func (svr *Service) Authenticator() Authenticator {
+	return svr.authenticator.Load().(Authenticator)
+}
+
+// setAuthenticator swaps in a new Authenticator, e.g. after an [auth]
+// config reload through the management api. Safe to call concurrently
+// with Authenticator.
+func (svr *Service) setAuthenticator(authenticator Authenticator) {
+	svr.authenticator.Store(authenticator)
+}
+
 func (svr *Service) HandleListener(l frpNet.Listener) {
 	// Listen for incoming connections from client.
 	for {
@@ -221,9 +334,24 @@ func (svr *Service) HandleListener(l frpNet.Listener) {
 			return
 		}
 
+		frpsMetrics.ActiveConnections.Inc()
+		emitEvent(Event{Type: EventConnOpen})
+
 		// Start a new goroutine for dealing connections.
 		go func(frpConn frpNet.Conn) {
+			defer frpsMetrics.ActiveConnections.Dec()
+			defer emitEvent(Event{Type: EventConnClose})
+
+			// A client that completed the mTLS handshake on frpConn is
+			// already authenticated; every TcpMux stream multiplexed over
+			// it inherits that same Principal.
+			var preAuth *Principal
+			if certConn, ok := frpConn.(*mtlsConn); ok {
+				preAuth = certConn.Principal
+			}
+
 			dealFn := func(conn frpNet.Conn) {
+				loginStart := time.Now()
 				var rawMsg msg.Message
 				conn.SetReadDeadline(time.Now().Add(connReadTimeout))
 				if rawMsg, err = msg.ReadMsg(conn); err != nil {
@@ -235,16 +363,19 @@ func (svr *Service) HandleListener(l frpNet.Listener) {
 
 				switch m := rawMsg.(type) {
 				case *msg.Login:
-					err = svr.RegisterControl(conn, m)
+					err = svr.RegisterControl(conn, m, preAuth)
 					// If login failed, send error message there.
 					// Otherwise send success message in control's work goroutine.
 					if err != nil {
+						frpsMetrics.AuthFailures.Inc()
 						conn.Warn("%v", err)
 						msg.WriteMsg(conn, &msg.LoginResp{
 							Version: version.Full(),
 							Error:   err.Error(),
 						})
 						conn.Close()
+					} else {
+						frpsMetrics.HandshakeLatency.Observe(time.Since(loginStart).Seconds())
 					}
 				case *msg.NewWorkConn:
 					svr.RegisterWorkConn(conn, m)
@@ -268,7 +399,22 @@ func (svr *Service) HandleListener(l frpNet.Listener) {
 				}
 			}
 
-			if g.GlbServerCfg.TcpMux {
+			// QUIC connections multiplex independently of TcpMux: every
+			// session already gives us further streams the same way a
+			// yamux session does, so dealFn is driven the same way for
+			// both once we have something that can AcceptStream.
+			if sa, ok := frpConn.(streamAccepter); ok {
+				dealFn(frpConn)
+				for {
+					stream, err := sa.AcceptStream()
+					if err != nil {
+						frpsMetrics.MuxStreamErrors.Inc()
+						log.Warn("Accept new quic stream error: %v", err)
+						return
+					}
+					go dealFn(stream)
+				}
+			} else if g.GlbServerCfg.TcpMux {
 				fmuxCfg := fmux.DefaultConfig()
 				fmuxCfg.LogOutput = ioutil.Discard
 				session, err := fmux.Server(frpConn, fmuxCfg)
@@ -281,6 +427,7 @@ func (svr *Service) HandleListener(l frpNet.Listener) {
 				for {
 					stream, err := session.AcceptStream()
 					if err != nil {
+						frpsMetrics.MuxStreamErrors.Inc()
 						log.Warn("Accept new mux stream error: %v", err)
 						session.Close()
 						return
@@ -295,7 +442,11 @@ func (svr *Service) HandleListener(l frpNet.Listener) {
 	}
 }
 
-func (svr *Service) RegisterControl(ctlConn frpNet.Conn, loginMsg *msg.Login) (err error) {
+// RegisterControl verifies a client's Login message and starts its
+// Control. If preAuth is non-nil, the client has already proven its
+// identity via a verified mTLS client certificate on this connection, so
+// the configured Authenticator is skipped in favor of preAuth.
+func (svr *Service) RegisterControl(ctlConn frpNet.Conn, loginMsg *msg.Login, preAuth *Principal) (err error) {
 	ctlConn.Info("client login info: ip [%s] version [%s] hostname [%s] os [%s] arch [%s]",
 		ctlConn.RemoteAddr().String(), loginMsg.Version, loginMsg.Hostname, loginMsg.Os, loginMsg.Arch)
 
@@ -305,15 +456,14 @@ func (svr *Service) RegisterControl(ctlConn frpNet.Conn, loginMsg *msg.Login) (e
 		return
 	}
 
-	// Check auth.
-	nowTime := time.Now().Unix()
-	if g.GlbServerCfg.AuthTimeout != 0 && nowTime-loginMsg.Timestamp > g.GlbServerCfg.AuthTimeout {
-		err = fmt.Errorf("authorization timeout")
-		return
-	}
-	if util.GetAuthKey(g.GlbServerCfg.Token, loginMsg.Timestamp) != loginMsg.PrivilegeKey {
-		err = fmt.Errorf("authorization failed")
-		return
+	// A verified client certificate already proves identity; otherwise
+	// fall back to the configured token/JWT/OIDC Authenticator.
+	principal := preAuth
+	if principal == nil {
+		principal, err = svr.Authenticator().Authenticate(loginMsg)
+		if err != nil {
+			return
+		}
 	}
 
 	// If client's RunId is empty, it's a new client, we just create a new controller.
@@ -326,6 +476,7 @@ func (svr *Service) RegisterControl(ctlConn frpNet.Conn, loginMsg *msg.Login) (e
 	}
 
 	ctl := NewControl(svr, ctlConn, loginMsg)
+	ctl.Principal = principal
 
 	if oldCtl := svr.ctlManager.Add(loginMsg.RunId, ctl); oldCtl != nil {
 		oldCtl.allShutdown.WaitDone()
@@ -334,31 +485,164 @@ func (svr *Service) RegisterControl(ctlConn frpNet.Conn, loginMsg *msg.Login) (e
 	ctlConn.AddLogPrefix(loginMsg.RunId)
 	ctl.Start()
 
+	// Stand up any proxies pre-provisioned for this client through the
+	// management api, so they come back on every reconnect instead of only
+	// ever existing in the on-disk ProvisionStore.
+	if provisioned := svr.ProvisionedProxiesFor(principal.Identity); len(provisioned) > 0 {
+		ctl.StartProvisionedProxies(provisioned)
+	}
+
 	// for statistics
 	StatsNewClient()
+	frpsMetrics.ClientCount.Inc()
+	emitEvent(Event{Type: EventClientLogin, RunId: loginMsg.RunId})
 	return
 }
 
 // RegisterWorkConn register a new work connection to control and proxies need it.
+//
+// workConn's own raw connection was already counted by HandleListener's
+// ActiveConnections.Inc/Dec pair around its accept goroutine, so this does
+// not bump the gauge again here: there would be no matching Dec once the
+// work connection itself closes, since that happens inside Control, not in
+// this function.
 func (svr *Service) RegisterWorkConn(workConn frpNet.Conn, newMsg *msg.NewWorkConn) {
 	ctl, exist := svr.ctlManager.GetById(newMsg.RunId)
 	if !exist {
 		workConn.Warn("No client control found for run id [%s]", newMsg.RunId)
+		frpsMetrics.AuthFailures.Inc()
 		return
 	}
 	ctl.RegisterWorkConn(workConn)
 	return
 }
 
+// CloseControl undoes the bookkeeping RegisterControl set up for runId:
+// ManageApi.handleDisconnect calls it right after forcing the control
+// connection closed. Control's own shutdown path (when a client simply
+// disconnects) is expected to call it the same way once it tears itself
+// down; that path lives in control.go, outside this request's scope.
+func (svr *Service) CloseControl(runId string) {
+	svr.ctlManager.Del(runId)
+	frpsMetrics.ClientCount.Dec()
+	emitEvent(Event{Type: EventClientLogout, RunId: runId})
+}
+
 func (svr *Service) RegisterVisitorConn(visitorConn frpNet.Conn, newMsg *msg.NewVisitorConn) error {
 	return svr.visitorManager.NewConn(newMsg.ProxyName, visitorConn, newMsg.Timestamp, newMsg.SignKey,
 		newMsg.UseEncryption, newMsg.UseCompression)
 }
 
-func (svr *Service) RegisterProxy(name string, pxy Proxy) error {
-	return svr.pxyManager.Add(name, pxy)
+// ProvisionedProxiesFor returns the proxies pre-provisioned through the
+// management API that clientIdentity is allowed to claim, so a client's
+// control goroutine can bring them up as soon as it connects.
+func (svr *Service) ProvisionedProxiesFor(clientIdentity string) []*ProvisionedProxy {
+	var allowed []*ProvisionedProxy
+	for _, p := range svr.provisionStore.List() {
+		if len(p.AllowedClients) == 0 {
+			allowed = append(allowed, p)
+			continue
+		}
+		for _, c := range p.AllowedClients {
+			if c == clientIdentity {
+				allowed = append(allowed, p)
+				break
+			}
+		}
+	}
+	return allowed
+}
+
+// RegisterProxy registers a proxy on behalf of principal, enforcing its ACL
+// (allowed name prefixes, remote ports and quota) before the proxy is ever
+// added to pxyManager or bound to a port. remotePort is 0 for proxy types
+// that don't bind a remote port (e.g. http/https vhost proxies). proxyType
+// is one of "tcp", "udp", "http", "https" and is only used to label
+// ProxyCount: a client that registers many distinct proxy names only ever
+// moves the needle on its own type's bounded series, not a new one.
+func (svr *Service) RegisterProxy(name string, pxy Proxy, principal *Principal, proxyType string, remotePort int) error {
+	if err := CheckProxyAcl(principal, name, remotePort); err != nil {
+		return err
+	}
+	identity, quota := "", 0
+	if principal != nil {
+		identity, quota = principal.Identity, principal.Quota
+	}
+	if err := svr.proxyQuota.reserve(identity, quota); err != nil {
+		return err
+	}
+	if err := svr.pxyManager.Add(name, pxy); err != nil {
+		svr.proxyQuota.release(identity)
+		return err
+	}
+	frpsMetrics.ProxyCount.WithLabelValues(proxyType).Inc()
+	emitEvent(Event{Type: EventProxyAdd, ProxyName: name})
+	return nil
 }
 
-func (svr *Service) DelProxy(name string) {
+// CheckProxyAcl reports whether principal is allowed to register a proxy
+// named name bound to remotePort (0 if the proxy type has no remote port,
+// e.g. http/https vhost proxies). It does not check principal's quota,
+// since that requires the caller's live proxy count: see
+// Service.RegisterProxy.
+func CheckProxyAcl(principal *Principal, name string, remotePort int) error {
+	if !principal.AllowProxyName(name) {
+		return fmt.Errorf("proxy name [%s] is not permitted by client's ACL", name)
+	}
+	if remotePort != 0 && !principal.AllowRemotePort(remotePort) {
+		return fmt.Errorf("remote port [%d] is not permitted by client's ACL", remotePort)
+	}
+	return nil
+}
+
+// DelProxy undoes the bookkeeping RegisterProxy set up for name: it must be
+// called with the same principal (or at least the same identity) that
+// registered it, so its quota reservation is released.
+func (svr *Service) DelProxy(name string, principal *Principal, proxyType string) {
 	svr.pxyManager.Del(name)
+	identity := ""
+	if principal != nil {
+		identity = principal.Identity
+	}
+	svr.proxyQuota.release(identity)
+	frpsMetrics.ProxyCount.WithLabelValues(proxyType).Dec()
+	emitEvent(Event{Type: EventProxyRemove, ProxyName: name})
+}
+
+// proxyQuota tracks how many proxies each principal currently has
+// registered, keyed by Principal.Identity, so RegisterProxy can enforce
+// Principal.Quota: proxies sitting in pxyManager aren't otherwise linked
+// back to the principal that registered them.
+type proxyQuota struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newProxyQuota() *proxyQuota {
+	return &proxyQuota{counts: make(map[string]int)}
+}
+
+// reserve increments identity's count and succeeds, unless quota is
+// positive and identity is already at or above it. A zero quota means
+// unlimited, matching Principal.Quota's own doc comment.
+func (q *proxyQuota) reserve(identity string, quota int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if quota > 0 && q.counts[identity] >= quota {
+		return fmt.Errorf("client [%s] has reached its quota of %d proxies", identity, quota)
+	}
+	q.counts[identity]++
+	return nil
+}
+
+func (q *proxyQuota) release(identity string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.counts[identity] == 0 {
+		return
+	}
+	q.counts[identity]--
+	if q.counts[identity] == 0 {
+		delete(q.counts, identity)
+	}
 }
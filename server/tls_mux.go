@@ -0,0 +1,154 @@
+// Copyright 2017 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"time"
+
+	"github.com/fatedier/frp/utils/log"
+	frpNet "github.com/fatedier/frp/utils/net"
+)
+
+// tlsHandshakeTimeout bounds how long a client may take to complete the
+// mTLS handshake before we give up on it.
+const tlsHandshakeTimeout = 10 * time.Second
+
+// tlsServerConfig builds the *tls.Config used to wrap the client control
+// listener when [mtls] is enabled: it requires and verifies a client
+// certificate against the configured CA, so a successfully completed
+// handshake already proves the client's identity.
+func tlsServerConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load server cert/key: %v", err)
+	}
+
+	caBytes, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("read client ca: %v", err)
+	}
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(caBytes) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    caPool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}, nil
+}
+
+// PrincipalFromClientCert turns a verified client certificate's CN/SAN into
+// the Principal RegisterControl should use, so a client presenting mTLS can
+// skip the token/JWT/OIDC check entirely.
+func PrincipalFromClientCert(cert *x509.Certificate) *Principal {
+	identity := cert.Subject.CommonName
+	if len(cert.DNSNames) > 0 {
+		identity = cert.DNSNames[0]
+	}
+	return &Principal{Identity: identity}
+}
+
+// tlsListener wraps a net.Listener so every accepted connection has
+// already completed a verified mTLS handshake before it is handed to
+// HandleListener, with the resulting Principal attached so RegisterControl
+// can skip its own auth check. acceptConns hands each raw connection off to
+// handshake in its own goroutine instead of running the handshake inline,
+// so a client that holds the TCP connection open without ever sending a
+// ClientHello can't hold up Accept for anyone else.
+type tlsListener struct {
+	inner   net.Listener
+	tlsConf *tls.Config
+	accept  chan frpNet.Conn
+	closed  chan struct{}
+}
+
+func NewTlsListener(inner net.Listener, tlsConf *tls.Config) frpNet.Listener {
+	l := &tlsListener{
+		inner:   inner,
+		tlsConf: tlsConf,
+		accept:  make(chan frpNet.Conn),
+		closed:  make(chan struct{}),
+	}
+	go l.acceptConns()
+	return l
+}
+
+// acceptConns only does the cheap part of Accept: taking the next raw
+// connection off inner. The expensive part, the TLS handshake, happens in
+// its own goroutine per connection.
+func (l *tlsListener) acceptConns() {
+	for {
+		c, err := l.inner.Accept()
+		if err != nil {
+			close(l.accept)
+			return
+		}
+		go l.handshake(c)
+	}
+}
+
+func (l *tlsListener) handshake(c net.Conn) {
+	c.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	tlsConn := tls.Server(c, l.tlsConf)
+	if err := tlsConn.Handshake(); err != nil {
+		log.Warn("tls handshake with [%s] failed: %v", c.RemoteAddr(), err)
+		tlsConn.Close()
+		return
+	}
+	c.SetDeadline(time.Time{})
+
+	var principal *Principal
+	if state := tlsConn.ConnectionState(); len(state.PeerCertificates) > 0 {
+		principal = PrincipalFromClientCert(state.PeerCertificates[0])
+	}
+	conn := &mtlsConn{Conn: frpNet.WrapConn(tlsConn), Principal: principal}
+	select {
+	case l.accept <- conn:
+	case <-l.closed:
+		conn.Close()
+	}
+}
+
+func (l *tlsListener) Accept() (frpNet.Conn, error) {
+	conn, ok := <-l.accept
+	if !ok {
+		return nil, fmt.Errorf("tls listener closed")
+	}
+	return conn, nil
+}
+
+func (l *tlsListener) Close() error {
+	close(l.closed)
+	return l.inner.Close()
+}
+
+func (l *tlsListener) Addr() net.Addr {
+	return l.inner.Addr()
+}
+
+// mtlsConn carries the Principal derived from the client certificate
+// alongside the connection, so RegisterControl can pick it up without
+// threading TLS state through the message layer.
+type mtlsConn struct {
+	frpNet.Conn
+	Principal *Principal
+}
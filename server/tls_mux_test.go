@@ -0,0 +1,40 @@
+// Copyright 2017 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+)
+
+func TestPrincipalFromClientCertPrefersSAN(t *testing.T) {
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "client-cn"},
+		DNSNames: []string{"client.internal"},
+	}
+	p := PrincipalFromClientCert(cert)
+	if p.Identity != "client.internal" {
+		t.Errorf("expected SAN to take precedence, got %q", p.Identity)
+	}
+}
+
+func TestPrincipalFromClientCertFallsBackToCommonName(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "client-cn"}}
+	p := PrincipalFromClientCert(cert)
+	if p.Identity != "client-cn" {
+		t.Errorf("expected CN fallback, got %q", p.Identity)
+	}
+}
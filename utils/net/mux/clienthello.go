@@ -0,0 +1,140 @@
+// Copyright 2017 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mux
+
+// tlsRecordTypeHandshake is the TLS record content type of a ClientHello
+// (RFC 8446 §5.1): the first byte of any TLS connection's first record.
+const tlsRecordTypeHandshake = 0x16
+
+const (
+	tlsHandshakeTypeClientHello = 0x01
+	tlsExtensionALPN            = 0x0010
+)
+
+// clientHelloOffersAlpn reports whether the TLS ClientHello at the start of
+// record parses cleanly and lists proto among its ALPN protocols. It never
+// errors: a record that isn't a well-formed ClientHello (truncated because
+// it didn't fit in the peek buffer, a TLS version this parser doesn't
+// expect, etc.) is simply treated as not offering proto, so the connection
+// falls back to the plain HTTPS vhost path instead of being dropped.
+func clientHelloOffersAlpn(record []byte, proto string) bool {
+	// Record header: type(1) + version(2) + length(2).
+	if len(record) < 5 || record[0] != tlsRecordTypeHandshake {
+		return false
+	}
+	body := record[5:]
+
+	// Handshake header: type(1) + length(3).
+	if len(body) < 4 || body[0] != tlsHandshakeTypeClientHello {
+		return false
+	}
+	body = body[4:]
+
+	// client_version(2) + random(32).
+	if len(body) < 34 {
+		return false
+	}
+	body = body[34:]
+
+	// session_id: length(1) + id.
+	if len(body) < 1 {
+		return false
+	}
+	sessIdLen := int(body[0])
+	body = body[1:]
+	if len(body) < sessIdLen {
+		return false
+	}
+	body = body[sessIdLen:]
+
+	// cipher_suites: length(2) + suites.
+	if len(body) < 2 {
+		return false
+	}
+	cipherLen := int(body[0])<<8 | int(body[1])
+	body = body[2:]
+	if len(body) < cipherLen {
+		return false
+	}
+	body = body[cipherLen:]
+
+	// compression_methods: length(1) + methods.
+	if len(body) < 1 {
+		return false
+	}
+	compLen := int(body[0])
+	body = body[1:]
+	if len(body) < compLen {
+		return false
+	}
+	body = body[compLen:]
+
+	// extensions: length(2) + extensions.
+	if len(body) < 2 {
+		return false
+	}
+	extsLen := int(body[0])<<8 | int(body[1])
+	body = body[2:]
+	if len(body) < extsLen {
+		extsLen = len(body)
+	}
+	exts := body[:extsLen]
+
+	for len(exts) >= 4 {
+		extType := int(exts[0])<<8 | int(exts[1])
+		extLen := int(exts[2])<<8 | int(exts[3])
+		exts = exts[4:]
+		if len(exts) < extLen {
+			return false
+		}
+		extData := exts[:extLen]
+		exts = exts[extLen:]
+
+		if extType != tlsExtensionALPN {
+			continue
+		}
+		if alpnListOffers(extData, proto) {
+			return true
+		}
+	}
+	return false
+}
+
+// alpnListOffers parses an ALPN extension body (RFC 7301 §3.1):
+// protocol_name_list length(2), then repeated [length(1), name] entries.
+func alpnListOffers(data []byte, proto string) bool {
+	if len(data) < 2 {
+		return false
+	}
+	listLen := int(data[0])<<8 | int(data[1])
+	data = data[2:]
+	if len(data) < listLen {
+		listLen = len(data)
+	}
+	list := data[:listLen]
+
+	for len(list) >= 1 {
+		nameLen := int(list[0])
+		list = list[1:]
+		if len(list) < nameLen {
+			return false
+		}
+		if string(list[:nameLen]) == proto {
+			return true
+		}
+		list = list[nameLen:]
+	}
+	return false
+}
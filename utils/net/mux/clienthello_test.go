@@ -0,0 +1,66 @@
+// Copyright 2017 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mux
+
+import "testing"
+
+// buildClientHello assembles a minimal TLS record wrapping a ClientHello
+// whose only extension is ALPN advertising protos, for exercising
+// clientHelloOffersAlpn without a real TLS handshake.
+func buildClientHello(protos []string) []byte {
+	var alpnList []byte
+	for _, p := range protos {
+		alpnList = append(alpnList, byte(len(p)))
+		alpnList = append(alpnList, []byte(p)...)
+	}
+	alpnExtData := append([]byte{byte(len(alpnList) >> 8), byte(len(alpnList))}, alpnList...)
+	alpnExt := append([]byte{0x00, 0x10, byte(len(alpnExtData) >> 8), byte(len(alpnExtData))}, alpnExtData...)
+
+	extensions := alpnExt
+	hello := []byte{0x03, 0x03}                   // client_version
+	hello = append(hello, make([]byte, 32)...)    // random
+	hello = append(hello, 0x00)                   // session_id length
+	hello = append(hello, 0x00, 0x02, 0x13, 0x01) // cipher_suites
+	hello = append(hello, 0x01, 0x00)             // compression_methods
+	hello = append(hello, byte(len(extensions)>>8), byte(len(extensions)))
+	hello = append(hello, extensions...)
+
+	handshake := append([]byte{tlsHandshakeTypeClientHello,
+		byte(len(hello) >> 16), byte(len(hello) >> 8), byte(len(hello))}, hello...)
+
+	record := append([]byte{tlsRecordTypeHandshake, 0x03, 0x01,
+		byte(len(handshake) >> 8), byte(len(handshake))}, handshake...)
+	return record
+}
+
+func TestClientHelloOffersAlpnMatch(t *testing.T) {
+	record := buildClientHello([]string{"http/1.1", "frp"})
+	if !clientHelloOffersAlpn(record, "frp") {
+		t.Error("expected frp to be found in the ALPN list")
+	}
+}
+
+func TestClientHelloOffersAlpnNoMatch(t *testing.T) {
+	record := buildClientHello([]string{"http/1.1", "h2"})
+	if clientHelloOffersAlpn(record, "frp") {
+		t.Error("expected frp not to be found in the ALPN list")
+	}
+}
+
+func TestClientHelloOffersAlpnMalformedRecord(t *testing.T) {
+	if clientHelloOffersAlpn([]byte{0x16, 0x03}, "frp") {
+		t.Error("expected a truncated record to be treated as not offering frp")
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2017 fatedier, fatedier@gmail.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mux lets several logical listeners share one physical TCP
+// listener by peeking each connection's first bytes before handing it off:
+// plain HTTP requests, TLS ClientHellos, and everything else (the raw frp
+// protocol) are routed to separate net.Listeners without consuming any
+// bytes the eventual handler still needs to read.
+package mux
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+)
+
+// errMuxListenerClosed is returned by a sub-listener's Accept once it has
+// been closed directly (as opposed to Mux.Serve's listener closing).
+var errMuxListenerClosed = errors.New("mux: listener closed")
+
+// helloPeekSize is how much of a new connection we're willing to buffer
+// before giving up on classifying it. A TLS ClientHello carrying SNI and a
+// handful of ALPN protocols comfortably fits well inside this.
+const helloPeekSize = 8192
+
+// Mux demultiplexes the connections accepted from one net.Listener across
+// up to four logical listeners: ListenHttp, ListenHttps, ListenTls (for
+// TLS connections that offer "frp" via ALPN) and DefaultListener (for
+// everything else). Each Listen* call returns immediately with a
+// net.Listener; routing only starts once Serve is called with the real
+// listener to multiplex.
+type Mux struct {
+	httpLn  *muxListener
+	httpsLn *muxListener
+	tlsLn   *muxListener
+	defLn   *muxListener
+}
+
+// NewMux creates a Mux with all four sub-listeners ready to hand out.
+func NewMux() *Mux {
+	return &Mux{
+		httpLn:  newMuxListener(),
+		httpsLn: newMuxListener(),
+		tlsLn:   newMuxListener(),
+		defLn:   newMuxListener(),
+	}
+}
+
+// ListenHttp returns the sub-listener that receives plain HTTP/1.x
+// requests. priority is accepted for forward compatibility with
+// registering more than one matcher of the same kind; Mux only ever keeps
+// one HTTP sub-listener so it is currently ignored.
+func (m *Mux) ListenHttp(priority int) net.Listener { return m.httpLn }
+
+// ListenHttps returns the sub-listener that receives TLS connections that
+// did not offer "frp" via ALPN (i.e. ordinary HTTPS vhost traffic).
+func (m *Mux) ListenHttps(priority int) net.Listener { return m.httpsLn }
+
+// ListenTls returns the sub-listener that receives TLS connections that
+// offered "frp" via ALPN, i.e. the TLS-wrapped frp control channel
+// multiplexed onto the same port as plain frp and HTTPS vhost traffic.
+func (m *Mux) ListenTls(priority int) net.Listener { return m.tlsLn }
+
+// DefaultListener returns the sub-listener that receives everything that
+// didn't match HTTP or TLS: the raw frp control protocol.
+func (m *Mux) DefaultListener() net.Listener { return m.defLn }
+
+// Serve accepts connections from ln, classifies each by its first bytes,
+// and routes it to the matching sub-listener's Accept. It blocks until ln
+// is closed, at which point every sub-listener is closed with the same
+// error so their Accept callers stop.
+func (m *Mux) Serve(ln net.Listener) error {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			m.closeAll(err)
+			return err
+		}
+		go m.route(c)
+	}
+}
+
+func (m *Mux) closeAll(err error) {
+	m.httpLn.closeWithError(err)
+	m.httpsLn.closeWithError(err)
+	m.tlsLn.closeWithError(err)
+	m.defLn.closeWithError(err)
+}
+
+func (m *Mux) route(c net.Conn) {
+	br := bufio.NewReaderSize(c, helloPeekSize)
+	peeked, err := br.Peek(1)
+	if err != nil {
+		c.Close()
+		return
+	}
+	conn := &sniffedConn{Conn: c, r: br}
+
+	switch {
+	case looksLikeHttpRequest(peeked[0]):
+		m.httpLn.deliver(conn)
+	case peeked[0] == tlsRecordTypeHandshake:
+		full, _ := br.Peek(br.Buffered())
+		if clientHelloOffersAlpn(full, "frp") {
+			m.tlsLn.deliver(conn)
+		} else {
+			m.httpsLn.deliver(conn)
+		}
+	default:
+		m.defLn.deliver(conn)
+	}
+}
+
+// looksLikeHttpRequest reports whether b is a byte an HTTP/1.x request
+// line could plausibly start with (the first letter of a request method).
+func looksLikeHttpRequest(b byte) bool {
+	switch b {
+	case 'G', 'H', 'P', 'D', 'C', 'O', 'T': // GET/HEAD, POST/PUT/PATCH, DELETE, CONNECT, OPTIONS, TRACE
+		return true
+	default:
+		return false
+	}
+}
+
+// sniffedConn is a net.Conn whose first bytes have already been peeked
+// into br; reads come from br first so nothing Mux looked at is lost.
+type sniffedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *sniffedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+// muxListener is the net.Listener handed out by Mux's Listen* methods. It
+// has no real socket of its own: connections arrive via deliver, which
+// Mux.route calls from its own goroutine per accepted connection.
+type muxListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+	err    error
+	mu     sync.Mutex
+}
+
+func newMuxListener() *muxListener {
+	return &muxListener{
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+func (l *muxListener) deliver(c net.Conn) {
+	select {
+	case l.conns <- c:
+	case <-l.closed:
+		c.Close()
+	}
+}
+
+func (l *muxListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.closed:
+		l.mu.Lock()
+		err := l.err
+		l.mu.Unlock()
+		return nil, err
+	}
+}
+
+func (l *muxListener) closeWithError(err error) {
+	l.mu.Lock()
+	l.err = err
+	l.mu.Unlock()
+	l.once.Do(func() { close(l.closed) })
+}
+
+func (l *muxListener) Close() error {
+	l.closeWithError(errMuxListenerClosed)
+	return nil
+}
+
+// Addr satisfies net.Listener; muxListener has no address of its own to
+// report, so it falls back to the zero value of net.TCPAddr.
+func (l *muxListener) Addr() net.Addr {
+	return &net.TCPAddr{}
+}